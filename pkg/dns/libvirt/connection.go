@@ -0,0 +1,51 @@
+package libvirt
+
+import (
+	libvirt "github.com/libvirt/libvirt-go"
+)
+
+// network is the subset of *libvirt.Network used by Provider, pulled out
+// into an interface so tests can exercise Provider against a fake
+// connection instead of a real libvirt daemon.
+type network interface {
+	GetName() (string, error)
+	GetXMLDesc(flags libvirt.NetworkXMLFlags) (string, error)
+	Update(cmd libvirt.NetworkUpdateCommand, section int, parentIndex int, xml string, flags libvirt.NetworkUpdateFlags) error
+	Free() error
+}
+
+// connection is the subset of *libvirt.Connect used by Provider.
+type connection interface {
+	IsAlive() (bool, error)
+	ListAllNetworks(flags libvirt.ConnectListAllNetworksFlags) ([]network, error)
+	RegisterCloseCallback(callback libvirt.ConnectCloseFunc) error
+	Close() (int, error)
+}
+
+// dialLibvirt is overridden by tests to avoid dialing a real libvirt daemon.
+var dialLibvirt = func(url string) (connection, error) {
+	conn, err := libvirt.NewConnect(url)
+	if err != nil {
+		return nil, err
+	}
+	return &realConnection{conn}, nil
+}
+
+// realConnection adapts *libvirt.Connect to the connection interface; its
+// only job is widening ListAllNetworks' return type from []libvirt.Network
+// to []network.
+type realConnection struct {
+	*libvirt.Connect
+}
+
+func (c *realConnection) ListAllNetworks(flags libvirt.ConnectListAllNetworksFlags) ([]network, error) {
+	nets, err := c.Connect.ListAllNetworks(flags)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]network, len(nets))
+	for i := range nets {
+		out[i] = &nets[i]
+	}
+	return out, nil
+}
@@ -2,25 +2,42 @@ package libvirt
 
 import (
 	"encoding/xml"
+	"errors"
+	"fmt"
 	"net"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	libvirt "github.com/libvirt/libvirt-go"
 
 	configv1 "github.com/openshift/api/config/v1"
 	iov1 "github.com/openshift/cluster-ingress-operator/pkg/api/v1"
 
+	"github.com/openshift/cluster-ingress-operator/pkg/dns"
 	logf "github.com/openshift/cluster-ingress-operator/pkg/log"
 )
 
 var (
-	// _ dns.Provider = &Provider{}
+	_ dns.Provider = &Provider{}
+
 	log = logf.Logger.WithName("entrypoint")
 )
 
 type Provider struct {
 	// config is required input.
 	config Config
+
+	// connMu guards conn, which is lazily dialed and cached across calls to
+	// Ensure/Delete so every reconcile doesn't pay for a fresh libvirt
+	// connection.
+	connMu sync.Mutex
+	conn   connection
+
+	// connClosed is set by conn's close callback when libvirt reports the
+	// connection has gone away, so connect() can redial even if IsAlive
+	// hasn't noticed yet. It's reset whenever a new conn is cached.
+	connClosed atomic.Bool
 }
 
 type Config struct {
@@ -28,10 +45,34 @@ type Config struct {
 	Domain  string
 	Url     string
 }
+
+// Host represents a libvirt network <host> DNS entry. A single IP can carry
+// more than one hostname, so Hostnames is a list, not a single value.
 type Host struct {
 	XMLName   xml.Name `xml:"host"`
 	Ip        string   `xml:"ip,attr"`
-	DnsRecord string   `xml:"hostname"`
+	Hostnames []string `xml:"hostname"`
+}
+
+// Srv represents a libvirt network <srv> DNS entry, used for SRV records.
+type Srv struct {
+	XMLName  xml.Name `xml:"srv"`
+	Service  string   `xml:"service,attr"`
+	Protocol string   `xml:"protocol,attr"`
+	Target   string   `xml:"target,attr"`
+	Port     string   `xml:"port,attr"`
+	Priority string   `xml:"priority,attr,omitempty"`
+	Weight   string   `xml:"weight,attr,omitempty"`
+}
+
+// networkDNS mirrors just enough of a libvirt network's <dns> element to
+// tell which host/srv entries it already has.
+type networkDNS struct {
+	XMLName xml.Name `xml:"network"`
+	DNS     struct {
+		Hosts []Host `xml:"host"`
+		Srvs  []Srv  `xml:"srv"`
+	} `xml:"dns"`
 }
 
 type action = libvirt.NetworkUpdateCommand
@@ -41,6 +82,23 @@ const (
 	deleteAction action = 2
 )
 
+// libvirt network XML update sections; see virNetworkUpdateSection in
+// libvirt/libvirt-network.h.
+const (
+	dnsHostSection = 10
+	dnsSrvSection  = 12
+)
+
+// unsupportedRecordTypeError is returned when a DNSRecord asks for a record
+// type the libvirt provider doesn't know how to program.
+type unsupportedRecordTypeError struct {
+	recordType iov1.DNSRecordType
+}
+
+func (e *unsupportedRecordTypeError) Error() string {
+	return fmt.Sprintf("unsupported DNS record type %q", e.recordType)
+}
+
 // New creates (but does not start) a new operator from configuration.
 func New(config Config) (*Provider, error) {
 	provider := &Provider{
@@ -57,58 +115,301 @@ func (p *Provider) Delete(record *iov1.DNSRecord, zone configv1.DNSZone) error {
 	return p.change(record, zone, deleteAction)
 }
 
-// Change methods will perform an action on a record.
-func (p *Provider) change(record *iov1.DNSRecord, zone configv1.DNSZone, action action) error {
-	// Create a new connections to qemu
-	conn, err := libvirt.NewConnect(p.config.Url)
+// connect returns the cached libvirt connection, reconnecting first if it's
+// unset or has gone stale.
+func (p *Provider) connect() (connection, error) {
+	p.connMu.Lock()
+	defer p.connMu.Unlock()
+
+	if p.conn != nil && !p.connClosed.Load() {
+		if alive, err := p.conn.IsAlive(); err == nil && alive {
+			return p.conn, nil
+		}
+	}
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+
+	conn, err := dialLibvirt(p.config.Url)
 	if err != nil {
-		log.Error(err, "failed to connect qemu")
+		return nil, fmt.Errorf("failed to connect to %s: %w", p.config.Url, err)
 	}
+	p.connClosed.Store(false)
+	if err := conn.RegisterCloseCallback(func(_ *libvirt.Connect, reason libvirt.ConnectCloseReason) {
+		log.Info("libvirt connection closed", "reason", reason)
+		p.connClosed.Store(true)
+	}); err != nil {
+		log.Error(err, "failed to register libvirt close callback")
+	}
+
+	p.conn = conn
+	return conn, nil
+}
 
-	// List all networks
-	networks, err := conn.ListAllNetworks(2)
+// change performs action against record, on every libvirt network belonging
+// to the configured cluster.  Per-network failures are joined together
+// rather than swallowed, so the caller's reconcile loop sees them and
+// retries.
+func (p *Provider) change(record *iov1.DNSRecord, zone configv1.DNSZone, action action) error {
+	conn, err := p.connect()
 	if err != nil {
-		log.Error(err, "failed to get networks")
+		return err
 	}
 
-	// Lookup app domain's ip
-	ips, err := net.LookupIP(p.config.Domain)
-	if err != nil || len(ips) == 0 {
-		log.Error(err, "failed to lookup app domain's IPs")
+	networks, err := conn.ListAllNetworks(libvirt.CONNECT_LIST_NETWORKS_ACTIVE)
+	if err != nil {
+		return fmt.Errorf("failed to list networks: %w", err)
 	}
-	log.Info("Domain nslookup", "IPs", ips)
 
-	// Find the correct network and update a DNS record
-	for _, network := range networks {
-		name, err := network.GetName()
+	var errs []error
+	for _, nw := range networks {
+		name, err := nw.GetName()
 		if err != nil {
-			log.Error(err, "failed to get network name")
+			errs = append(errs, fmt.Errorf("failed to get network name: %w", err))
+			nw.Free()
+			continue
 		}
 		if strings.Index(name, p.config.Cluster) == -1 {
 			log.Info("find the network but not in use", "network", name)
+			nw.Free()
 			continue
 		}
 		log.Info("found network in use", "network", name)
-		for _, ip := range ips {
-			// Generate a XML for DNS host
-			v := &Host{Ip: ip.String(), DnsRecord: record.Spec.DNSName}
-			output, err := xml.MarshalIndent(v, "  ", "    ")
-			if err != nil {
-				log.Error(err, "failed to generate a network XML")
+
+		if err := changeNetwork(nw, record, action); err != nil {
+			errs = append(errs, fmt.Errorf("network %s: %w", name, err))
+		}
+		nw.Free()
+	}
+	return errors.Join(errs...)
+}
+
+// changeNetwork applies action for record against a single libvirt network.
+// A/AAAA records are reconciled host-entry-by-host-entry so that calling
+// Ensure on every reconcile is idempotent; SRV records are applied
+// target-by-target.
+func changeNetwork(network network, record *iov1.DNSRecord, action action) error {
+	switch record.Spec.RecordType {
+	case iov1.ARecordType, iov1.AAAARecordType:
+		return reconcileHosts(network, record, action)
+	case iov1.SRVRecordType:
+		return changeSRVs(network, record, action)
+	default:
+		return &unsupportedRecordTypeError{recordType: record.Spec.RecordType}
+	}
+}
+
+// reconcileHosts brings a network's <host> entries in line with record: the
+// desired set is derived from record.Spec.Targets (empty if action is a
+// delete).  Existing entries for a desired IP are merged in with MODIFY
+// rather than blindly appended, entries for a genuinely new IP are added
+// with ADD_FIRST, and this record's hostname is removed from entries for
+// IPs that are no longer desired -- deleting the entry outright once no
+// hostname is left on it.  This makes Ensure safe to call on every
+// reconcile, including when the entry already exists.
+func reconcileHosts(network network, record *iov1.DNSRecord, action action) error {
+	existing, err := currentHosts(network)
+	if err != nil {
+		return err
+	}
+	byIP := make(map[string]Host, len(existing))
+	for _, h := range existing {
+		byIP[h.Ip] = h
+	}
+
+	desiredIPs := map[string]bool{}
+	if action != deleteAction {
+		for _, target := range record.Spec.Targets {
+			ip := net.ParseIP(target)
+			if ip == nil {
+				return fmt.Errorf("target %q is not a valid IP address", target)
+			}
+			desiredIPs[ip.String()] = true
+		}
+	}
+
+	for ip := range desiredIPs {
+		current, exists := byIP[ip]
+		if !exists {
+			if err := applyHost(network, libvirt.NETWORK_UPDATE_COMMAND_ADD_FIRST, Host{Ip: ip, Hostnames: []string{record.Spec.DNSName}}); err != nil {
+				return err
 			}
-			log.Info("DNS record is updating", "XML", string(output))
-			// Update the network
-			log.Info("DNS record is updating", "action", action)
-			err = network.Update(action, 10, -1, string(output), 0)
-			if err != nil {
-				log.Error(err, "failed to update network")
+			continue
+		}
+		if containsString(current.Hostnames, record.Spec.DNSName) {
+			continue
+		}
+		merged := Host{Ip: ip, Hostnames: append(append([]string{}, current.Hostnames...), record.Spec.DNSName)}
+		if err := applyHost(network, libvirt.NETWORK_UPDATE_COMMAND_MODIFY, merged); err != nil {
+			return err
+		}
+	}
+
+	for ip, h := range byIP {
+		if desiredIPs[ip] || !containsString(h.Hostnames, record.Spec.DNSName) {
+			continue
+		}
+		remaining := removeString(h.Hostnames, record.Spec.DNSName)
+		if len(remaining) == 0 {
+			if err := applyHost(network, deleteAction, h); err != nil {
+				return err
 			}
+			continue
 		}
+		if err := applyHost(network, libvirt.NETWORK_UPDATE_COMMAND_MODIFY, Host{Ip: ip, Hostnames: remaining}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// currentHosts fetches network's existing <host> entries.
+func currentHosts(network network) ([]Host, error) {
+	raw, err := network.GetXMLDesc(libvirt.NETWORK_XML_INACTIVE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get network XML: %w", err)
+	}
+	var desc networkDNS
+	if err := xml.Unmarshal([]byte(raw), &desc); err != nil {
+		return nil, fmt.Errorf("failed to parse network XML: %w", err)
+	}
+	return desc.DNS.Hosts, nil
+}
+
+// applyHost issues a libvirt network DNS host update.
+func applyHost(network network, cmd action, host Host) error {
+	raw, err := xml.MarshalIndent(host, "  ", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to generate host XML: %w", err)
+	}
+	log.Info("DNS host entry is updating", "command", cmd, "XML", string(raw))
+	if err := network.Update(cmd, dnsHostSection, -1, string(raw), 0); err != nil {
+		return fmt.Errorf("failed to update network: %w", err)
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func removeString(list []string, s string) []string {
+	out := make([]string, 0, len(list))
+	for _, v := range list {
+		if v != s {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// changeSRVs applies action to every target of an SRV record against a
+// single libvirt network.  Unlike host entries, a SRV entry's identity is
+// its whole XML body, so whether it already exists is checked before every
+// update, not just deletes -- otherwise calling Ensure on every reconcile
+// would re-add the same entry each time.
+func changeSRVs(network network, record *iov1.DNSRecord, action action) error {
+	for _, target := range record.Spec.Targets {
+		output, err := srvEntryFor(record, target)
+		if err != nil {
+			return err
+		}
+
+		exists, err := srvEntryExists(network, output)
 		if err != nil {
-			log.Error(err, "failed to get networks")
+			return err
+		}
+		if action == deleteAction && !exists {
+			log.Info("DNS entry does not exist, skipping delete", "target", target)
+			continue
+		}
+		if action != deleteAction && exists {
+			log.Info("DNS entry already exists, skipping add", "target", target)
+			continue
+		}
+
+		log.Info("DNS record is updating", "action", action, "XML", output)
+		if err := network.Update(action, dnsSrvSection, -1, output, 0); err != nil {
+			return fmt.Errorf("failed to update network: %w", err)
 		}
-		network.Free()
 	}
-	conn.Close()
 	return nil
 }
+
+// srvEntryFor builds the libvirt <srv> update XML for a single target of an
+// SRV record.
+func srvEntryFor(record *iov1.DNSRecord, target string) (string, error) {
+	service, protocol, err := splitSRVName(record.Spec.DNSName)
+	if err != nil {
+		return "", err
+	}
+	priority, weight, port, host, err := splitSRVTarget(target)
+	if err != nil {
+		return "", err
+	}
+	srv := Srv{
+		Service:  service,
+		Protocol: protocol,
+		Target:   host,
+		Port:     port,
+		Priority: priority,
+		Weight:   weight,
+	}
+	raw, err := xml.MarshalIndent(srv, "  ", "    ")
+	if err != nil {
+		return "", fmt.Errorf("failed to generate srv XML: %w", err)
+	}
+	return string(raw), nil
+}
+
+// splitSRVName pulls the service and protocol out of a SRV DNSRecord's name,
+// which is expected to be formatted "_service._proto.name.".
+func splitSRVName(dnsName string) (service, protocol string, err error) {
+	labels := strings.SplitN(strings.TrimSuffix(dnsName, "."), ".", 3)
+	if len(labels) < 2 || !strings.HasPrefix(labels[0], "_") || !strings.HasPrefix(labels[1], "_") {
+		return "", "", fmt.Errorf("SRV record name %q must be formatted as \"_service._proto.name\"", dnsName)
+	}
+	return strings.TrimPrefix(labels[0], "_"), strings.TrimPrefix(labels[1], "_"), nil
+}
+
+// splitSRVTarget parses a SRV target, which is expected to be formatted as
+// the standard SRV RDATA "<priority> <weight> <port> <host>".
+func splitSRVTarget(target string) (priority, weight, port, host string, err error) {
+	fields := strings.Fields(target)
+	if len(fields) != 4 {
+		return "", "", "", "", fmt.Errorf("SRV target %q must be formatted as \"<priority> <weight> <port> <host>\"", target)
+	}
+	return fields[0], fields[1], fields[2], fields[3], nil
+}
+
+// srvEntryExists reports whether network already has the <srv> entry
+// described by desired, so changeSRVs can skip adding an entry that is
+// already there and skip (and safely retry) deleting one that is gone.
+func srvEntryExists(network network, desired string) (bool, error) {
+	raw, err := network.GetXMLDesc(libvirt.NETWORK_XML_INACTIVE)
+	if err != nil {
+		return false, fmt.Errorf("failed to get network XML: %w", err)
+	}
+	var desc networkDNS
+	if err := xml.Unmarshal([]byte(raw), &desc); err != nil {
+		return false, fmt.Errorf("failed to parse network XML: %w", err)
+	}
+
+	var want Srv
+	if err := xml.Unmarshal([]byte(desired), &want); err != nil {
+		return false, fmt.Errorf("failed to parse srv XML: %w", err)
+	}
+	for _, got := range desc.DNS.Srvs {
+		if got == want {
+			return true, nil
+		}
+	}
+	return false, nil
+}
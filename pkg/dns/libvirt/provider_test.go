@@ -0,0 +1,273 @@
+package libvirt
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	libvirt "github.com/libvirt/libvirt-go"
+
+	configv1 "github.com/openshift/api/config/v1"
+	iov1 "github.com/openshift/cluster-ingress-operator/pkg/api/v1"
+)
+
+// fakeConnection is the internal seam's test double for a *libvirt.Connect.
+type fakeConnection struct {
+	alive         bool
+	networks      []network
+	listErr       error
+	closeCalls    int
+	closeCallback libvirt.ConnectCloseFunc
+}
+
+func (f *fakeConnection) IsAlive() (bool, error) { return f.alive, nil }
+
+func (f *fakeConnection) ListAllNetworks(flags libvirt.ConnectListAllNetworksFlags) ([]network, error) {
+	return f.networks, f.listErr
+}
+
+func (f *fakeConnection) RegisterCloseCallback(callback libvirt.ConnectCloseFunc) error {
+	f.closeCallback = callback
+	return nil
+}
+
+func (f *fakeConnection) Close() (int, error) {
+	f.closeCalls++
+	return 0, nil
+}
+
+// fakeNetwork is the test double for a *libvirt.Network. xmlDesc seeds the
+// network's existing <dns> entries; it defaults to an empty block when
+// unset, so most tests take the "nothing exists yet" path.
+type fakeNetwork struct {
+	name      string
+	xmlDesc   string
+	updateErr error
+	updates   int
+}
+
+func (f *fakeNetwork) GetName() (string, error) { return f.name, nil }
+
+func (f *fakeNetwork) GetXMLDesc(flags libvirt.NetworkXMLFlags) (string, error) {
+	if f.xmlDesc == "" {
+		return "<network><dns></dns></network>", nil
+	}
+	return f.xmlDesc, nil
+}
+
+func (f *fakeNetwork) Update(cmd libvirt.NetworkUpdateCommand, section int, parentIndex int, xmlStr string, flags libvirt.NetworkUpdateFlags) error {
+	f.updates++
+	return f.updateErr
+}
+
+func (f *fakeNetwork) Free() error { return nil }
+
+func testRecord() *iov1.DNSRecord {
+	return &iov1.DNSRecord{
+		Spec: iov1.DNSRecordSpec{
+			DNSName:    "api.cluster.example.com.",
+			RecordType: iov1.ARecordType,
+			Targets:    []string{"192.0.2.1"},
+		},
+	}
+}
+
+func TestChangeReconnectsWhenCachedConnectionIsStale(t *testing.T) {
+	origDial := dialLibvirt
+	defer func() { dialLibvirt = origDial }()
+
+	dials := 0
+	net1 := &fakeNetwork{name: "cluster-net"}
+	dialLibvirt = func(url string) (connection, error) {
+		dials++
+		return &fakeConnection{alive: true, networks: []network{net1}}, nil
+	}
+
+	p := &Provider{config: Config{Cluster: "cluster", Url: "qemu:///system"}}
+	stale := &fakeConnection{alive: false}
+	p.conn = stale
+
+	if err := p.Ensure(testRecord(), configv1.DNSZone{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if dials != 1 {
+		t.Errorf("expected exactly one reconnect, got %d", dials)
+	}
+	if stale.closeCalls != 1 {
+		t.Errorf("expected the stale connection to be closed, got %d close calls", stale.closeCalls)
+	}
+	if net1.updates != 1 {
+		t.Errorf("expected 1 update on the matching network, got %d", net1.updates)
+	}
+}
+
+func TestChangeReconnectsWhenCloseCallbackFires(t *testing.T) {
+	origDial := dialLibvirt
+	defer func() { dialLibvirt = origDial }()
+
+	dials := 0
+	net1 := &fakeNetwork{name: "cluster-net"}
+	var conns []*fakeConnection
+	dialLibvirt = func(url string) (connection, error) {
+		dials++
+		c := &fakeConnection{alive: true, networks: []network{net1}}
+		conns = append(conns, c)
+		return c, nil
+	}
+
+	p := &Provider{config: Config{Cluster: "cluster", Url: "qemu:///system"}}
+
+	if err := p.Ensure(testRecord(), configv1.DNSZone{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if dials != 1 {
+		t.Fatalf("expected exactly one dial, got %d", dials)
+	}
+
+	// Simulate libvirt reporting the connection as closed out-of-band. The
+	// fake still reports alive=true, so only the close callback's flag
+	// should trigger a reconnect.
+	conns[0].closeCallback(nil, libvirt.CONNECT_CLOSE_REASON_ERROR)
+
+	if err := p.Ensure(testRecord(), configv1.DNSZone{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if dials != 2 {
+		t.Errorf("expected the close callback to force a reconnect, got %d dials", dials)
+	}
+	if conns[0].closeCalls != 1 {
+		t.Errorf("expected the closed connection to be closed before redialing, got %d close calls", conns[0].closeCalls)
+	}
+}
+
+func srvTestRecord() *iov1.DNSRecord {
+	return &iov1.DNSRecord{
+		Spec: iov1.DNSRecordSpec{
+			DNSName:    "_ldap._tcp.cluster.example.com.",
+			RecordType: iov1.SRVRecordType,
+			Targets:    []string{"0 100 389 ldap.cluster.example.com."},
+		},
+	}
+}
+
+func TestChangeSRVsSkipsUpsertWhenEntryAlreadyExists(t *testing.T) {
+	origDial := dialLibvirt
+	defer func() { dialLibvirt = origDial }()
+
+	existing := "<network><dns><srv service='ldap' protocol='tcp' target='ldap.cluster.example.com.' port='389' priority='0' weight='100'></srv></dns></network>"
+	net1 := &fakeNetwork{name: "cluster-net", xmlDesc: existing}
+	dialLibvirt = func(url string) (connection, error) {
+		return &fakeConnection{alive: true, networks: []network{net1}}, nil
+	}
+
+	p := &Provider{config: Config{Cluster: "cluster", Url: "qemu:///system"}}
+
+	if err := p.Ensure(srvTestRecord(), configv1.DNSZone{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if net1.updates != 0 {
+		t.Errorf("expected no update when the SRV entry already exists, got %d", net1.updates)
+	}
+}
+
+func TestChangeSRVsAddsEntryWhenMissing(t *testing.T) {
+	origDial := dialLibvirt
+	defer func() { dialLibvirt = origDial }()
+
+	net1 := &fakeNetwork{name: "cluster-net"}
+	dialLibvirt = func(url string) (connection, error) {
+		return &fakeConnection{alive: true, networks: []network{net1}}, nil
+	}
+
+	p := &Provider{config: Config{Cluster: "cluster", Url: "qemu:///system"}}
+
+	if err := p.Ensure(srvTestRecord(), configv1.DNSZone{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if net1.updates != 1 {
+		t.Errorf("expected exactly 1 update when the SRV entry is missing, got %d", net1.updates)
+	}
+}
+
+func TestReconcileHostsAddsNewHostForNewIP(t *testing.T) {
+	net1 := &fakeNetwork{name: "cluster-net"}
+
+	if err := reconcileHosts(net1, testRecord(), upsertAction); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if net1.updates != 1 {
+		t.Errorf("expected 1 update for a new IP, got %d", net1.updates)
+	}
+}
+
+func TestReconcileHostsSkipsWhenHostnameAlreadyPresent(t *testing.T) {
+	existing := "<network><dns><host ip='192.0.2.1'><hostname>api.cluster.example.com.</hostname></host></dns></network>"
+	net1 := &fakeNetwork{name: "cluster-net", xmlDesc: existing}
+
+	if err := reconcileHosts(net1, testRecord(), upsertAction); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if net1.updates != 0 {
+		t.Errorf("expected no update when the hostname is already on the IP, got %d", net1.updates)
+	}
+}
+
+func TestReconcileHostsMergesHostnameOntoSharedIP(t *testing.T) {
+	existing := "<network><dns><host ip='192.0.2.1'><hostname>other.cluster.example.com.</hostname></host></dns></network>"
+	net1 := &fakeNetwork{name: "cluster-net", xmlDesc: existing}
+
+	if err := reconcileHosts(net1, testRecord(), upsertAction); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if net1.updates != 1 {
+		t.Fatalf("expected 1 MODIFY update merging the hostname, got %d", net1.updates)
+	}
+}
+
+func TestReconcileHostsRemovesHostnameOnDeleteLeavingOthers(t *testing.T) {
+	existing := "<network><dns><host ip='192.0.2.1'><hostname>api.cluster.example.com.</hostname><hostname>other.cluster.example.com.</hostname></host></dns></network>"
+	net1 := &fakeNetwork{name: "cluster-net", xmlDesc: existing}
+
+	if err := reconcileHosts(net1, testRecord(), deleteAction); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if net1.updates != 1 {
+		t.Fatalf("expected 1 MODIFY update removing just this hostname, got %d", net1.updates)
+	}
+}
+
+func TestReconcileHostsDeletesHostWhenLastHostnameRemoved(t *testing.T) {
+	existing := "<network><dns><host ip='192.0.2.1'><hostname>api.cluster.example.com.</hostname></host></dns></network>"
+	net1 := &fakeNetwork{name: "cluster-net", xmlDesc: existing}
+
+	if err := reconcileHosts(net1, testRecord(), deleteAction); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if net1.updates != 1 {
+		t.Fatalf("expected 1 delete update removing the whole host entry, got %d", net1.updates)
+	}
+}
+
+func TestChangeJoinsPerNetworkErrors(t *testing.T) {
+	origDial := dialLibvirt
+	defer func() { dialLibvirt = origDial }()
+
+	failing := &fakeNetwork{name: "cluster-net-1", updateErr: errors.New("boom")}
+	other := &fakeNetwork{name: "cluster-net-2"}
+	dialLibvirt = func(url string) (connection, error) {
+		return &fakeConnection{alive: true, networks: []network{failing, other}}, nil
+	}
+
+	p := &Provider{config: Config{Cluster: "cluster", Url: "qemu:///system"}}
+
+	err := p.Ensure(testRecord(), configv1.DNSZone{})
+	if err == nil {
+		t.Fatal("expected an error from the failing network")
+	}
+	if !strings.Contains(err.Error(), "boom") {
+		t.Errorf("expected the joined error to include the underlying failure, got %v", err)
+	}
+	if other.updates != 1 {
+		t.Errorf("expected the other network to still be updated despite the failure, got %d", other.updates)
+	}
+}
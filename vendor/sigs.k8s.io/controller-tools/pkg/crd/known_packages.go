@@ -0,0 +1,107 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crd
+
+import (
+	apiext "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+
+	"sigs.k8s.io/controller-tools/pkg/loader"
+)
+
+// KnownPackages pre-registers PackageOverrides for a handful of common
+// Kubernetes API machinery types whose Go struct shape doesn't translate
+// into a sensible structural schema: resource.Quantity is really a string,
+// intstr.IntOrString is an int-or-string union, RawExtension and
+// Unstructured are deliberately untyped, etc.  Parser.init copies these into
+// PackageOverrides for any package path that hasn't already got an override
+// set, so schema generation works out of the box the first time a type
+// references one of them.
+//
+// A caller can opt out of (or replace) any one of these by setting
+// PackageOverrides[path] themselves before the parser is used -- including
+// setting it to nil, which falls back to the default (type-inferred, and
+// likely nonsensical) schema for that package.
+var KnownPackages = map[string]PackageOverride{
+	"k8s.io/apimachinery/pkg/api/resource": packageOverride(map[string]apiext.JSONSchemaProps{
+		"Quantity": {
+			Type:    "string",
+			Pattern: `^(\+|-)?(([0-9]+(\.[0-9]*)?)|(\.[0-9]+))(([KMGTPE]i)|[numkMGTPE]|([eE](\+|-)?(([0-9]+(\.[0-9]*)?)|(\.[0-9]+))))?$`,
+		},
+	}),
+
+	"k8s.io/apimachinery/pkg/util/intstr": packageOverride(map[string]apiext.JSONSchemaProps{
+		"IntOrString": {
+			XIntOrString: true,
+			AnyOf: []apiext.JSONSchemaProps{
+				{Type: "integer"},
+				{Type: "string"},
+			},
+		},
+	}),
+
+	"k8s.io/apimachinery/pkg/runtime": packageOverride(map[string]apiext.JSONSchemaProps{
+		"RawExtension": {
+			XPreserveUnknownFields: preserveUnknownFields,
+		},
+	}),
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1": packageOverride(map[string]apiext.JSONSchemaProps{
+		"ObjectMeta": {
+			Type: "object",
+		},
+		"Time": {
+			Type:   "string",
+			Format: "date-time",
+		},
+		"MicroTime": {
+			Type:   "string",
+			Format: "date-time",
+		},
+		"Duration": {
+			Type: "string",
+		},
+	}),
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured": packageOverride(map[string]apiext.JSONSchemaProps{
+		"Unstructured": {
+			XPreserveUnknownFields: preserveUnknownFields,
+		},
+		"UnstructuredList": {
+			XPreserveUnknownFields: preserveUnknownFields,
+		},
+	}),
+}
+
+// preserveUnknownFields is shared by the KnownPackages entries that need an
+// "x-kubernetes-preserve-unknown-fields: true" schema.
+var preserveUnknownFields = boolPtr(true)
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+// packageOverride builds a PackageOverride that pre-seeds known-good
+// schemata for the named types in a package, then continues on to the
+// normal loading path (AddPackage) for anything else declared there.
+func packageOverride(schemata map[string]apiext.JSONSchemaProps) PackageOverride {
+	return func(p *Parser, pkg *loader.Package) {
+		for name, sch := range schemata {
+			p.Schemata[TypeIdent{Package: pkg, Name: name}] = sch
+		}
+		p.AddPackage(pkg)
+	}
+}
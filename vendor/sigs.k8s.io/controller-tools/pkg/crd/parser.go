@@ -21,6 +21,7 @@ import (
 	"go/ast"
 
 	apiext "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 
 	"sigs.k8s.io/controller-tools/pkg/loader"
@@ -58,6 +59,11 @@ type Parser struct {
 	GroupVersions map[*loader.Package]schema.GroupVersion
 	// CustomResourceDefinitions contains the known CustomResourceDefinitions for types in this parser.
 	CustomResourceDefinitions map[schema.GroupKind]apiext.CustomResourceDefinition
+	// CustomResourceDefinitionsV1 contains the known apiextensions/v1 CustomResourceDefinitions
+	// for types in this parser.  It's populated by NeedCRDForV1, and is kept separate from
+	// CustomResourceDefinitions so that callers can pick whichever API version their cluster
+	// (or generator) targets without requiring both to be built.
+	CustomResourceDefinitionsV1 map[schema.GroupKind]apiextv1.CustomResourceDefinition
 
 	// PackageOverrides indicates that the loading of any package with
 	// the given path should be handled by the given overrider.
@@ -89,12 +95,20 @@ func (p *Parser) init() {
 	if p.PackageOverrides == nil {
 		p.PackageOverrides = make(map[string]PackageOverride)
 	}
+	for path, override := range KnownPackages {
+		if _, set := p.PackageOverrides[path]; !set {
+			p.PackageOverrides[path] = override
+		}
+	}
 	if p.GroupVersions == nil {
 		p.GroupVersions = make(map[*loader.Package]schema.GroupVersion)
 	}
 	if p.CustomResourceDefinitions == nil {
 		p.CustomResourceDefinitions = make(map[schema.GroupKind]apiext.CustomResourceDefinition)
 	}
+	if p.CustomResourceDefinitionsV1 == nil {
+		p.CustomResourceDefinitionsV1 = make(map[schema.GroupKind]apiextv1.CustomResourceDefinition)
+	}
 }
 
 // indexTypes loads all types in the package into Types.
@@ -160,6 +174,8 @@ func (p *Parser) NeedSchemaFor(typ TypeIdent) {
 
 // NeedCRDFor lives off in spec.go
 
+// NeedCRDForV1 lives off in spec_v1.go
+
 // AddPackage indicates that types and type-checking information is needed
 // for the the given package, *ignoring* overrides.
 // Generally, consumers should call NeedPackage, while PackageOverrides should
@@ -184,6 +200,11 @@ func (p *Parser) NeedPackage(pkg *loader.Package) {
 	// overrides are going to be written without vendor.  This is why we index by the actual
 	// object when we can.
 	if override, overridden := p.PackageOverrides[loader.NonVendorPath(pkg.PkgPath)]; overridden {
+		if override == nil {
+			// an explicit nil opts back out of a KnownPackages default
+			p.AddPackage(pkg)
+			return
+		}
 		override(p, pkg)
 		p.packages[pkg] = struct{}{}
 		return
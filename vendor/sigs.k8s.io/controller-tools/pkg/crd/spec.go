@@ -0,0 +1,98 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crd
+
+import (
+	apiext "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"sigs.k8s.io/controller-tools/pkg/markers"
+)
+
+// NeedCRDFor indicates that a CustomResourceDefinition should be generated for
+// the given group-kind, and loads information about it if necessary.
+//
+// Multiple packages may declare the same groupName with different
+// versionName markers (e.g. separate "./v1beta1" and "./v1" roots).  When
+// that's the case, the resulting CRD gets one spec.versions[] entry per
+// matching package, each with its own independently-generated schema, and
+// NeedCRDFor picks the single version whose root type carries a
+// "+kubebuilder:storageversion" marker as the storage version.
+func (p *Parser) NeedCRDFor(groupKind schema.GroupKind, maxDescLen *int) {
+	p.init()
+
+	_, resolved, storage := p.resolveVersionsFor(groupKind)
+	if resolved == nil {
+		return
+	}
+
+	var versions []apiext.CustomResourceDefinitionVersion
+	for _, v := range resolved {
+		p.NeedSchemaFor(v.typeIdent)
+		fullSchema := *p.Schemata[v.typeIdent].DeepCopy()
+		fullSchema = p.flattener.FlattenEmbedded(fullSchema, v.pkg)
+		if maxDescLen != nil {
+			trimDescription(&fullSchema, *maxDescLen)
+		}
+
+		versions = append(versions, apiext.CustomResourceDefinitionVersion{
+			Name:   v.version,
+			Served: true,
+			Schema: &apiext.CustomResourceValidation{
+				OpenAPIV3Schema: &fullSchema,
+			},
+			Subresources: v1beta1Subresources(fullSchema),
+			Storage:      v == storage,
+		})
+	}
+
+	p.CustomResourceDefinitions[groupKind] = apiext.CustomResourceDefinition{
+		Spec: apiext.CustomResourceDefinitionSpec{
+			Group: groupKind.Group,
+			Names: apiext.CustomResourceDefinitionNames{
+				Kind:     groupKind.Kind,
+				ListKind: groupKind.Kind + "List",
+				Plural:   defaultPlural(groupKind.Kind),
+				Singular: defaultSingular(groupKind.Kind),
+			},
+			Versions: versions,
+		},
+	}
+}
+
+// hasStorageVersionMarker reports whether the given type's root marker set
+// contains "+kubebuilder:storageversion".
+func hasStorageVersionMarker(info *markers.TypeInfo) bool {
+	if info == nil {
+		return false
+	}
+	return info.Markers.Get("kubebuilder:storageversion") != nil
+}
+
+// v1beta1Subresources derives the CRD subresources block from a generated
+// schema: a type that has a "status" property gets the status subresource.
+func v1beta1Subresources(schema apiext.JSONSchemaProps) *apiext.CustomResourceSubresources {
+	if _, hasStatus := schema.Properties["status"]; !hasStatus {
+		return nil
+	}
+	return &apiext.CustomResourceSubresources{
+		Status: &apiext.CustomResourceSubresourceStatus{},
+	}
+}
+
+// trimDescription, defaultPlural, and defaultSingular are defined in
+// spec_v1.go and shared by both the v1beta1 and v1 code paths.
@@ -0,0 +1,155 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	apiext "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// NeedCRDForV1 indicates that an apiextensions/v1 CustomResourceDefinition should
+// be generated for the given group-kind, using the same root type and schema
+// discovery as NeedCRDFor.  The v1 result is stored separately in
+// CustomResourceDefinitionsV1, so a caller that needs both API versions can ask
+// for each independently.  Like NeedCRDFor, several packages sharing groupKind's
+// group are folded into one CRD with one spec.versions[] entry per package.
+func (p *Parser) NeedCRDForV1(groupKind schema.GroupKind, maxDescLen *int) {
+	p.init()
+
+	_, resolved, storage := p.resolveVersionsFor(groupKind)
+	if resolved == nil {
+		return
+	}
+
+	var versions []apiextv1.CustomResourceDefinitionVersion
+	for _, v := range resolved {
+		p.NeedSchemaFor(v.typeIdent)
+		v1beta1Schema := *p.Schemata[v.typeIdent].DeepCopy()
+		v1beta1Schema = p.flattener.FlattenEmbedded(v1beta1Schema, v.pkg)
+		if maxDescLen != nil {
+			trimDescription(&v1beta1Schema, *maxDescLen)
+		}
+
+		fullSchema, err := schemaToV1(&v1beta1Schema)
+		if err != nil {
+			v.pkg.AddError(fmt.Errorf("unable to convert schema for %s to apiextensions/v1: %w", v.typeIdent, err))
+			return
+		}
+
+		versions = append(versions, apiextv1.CustomResourceDefinitionVersion{
+			Name:   v.version,
+			Served: true,
+			Schema: &apiextv1.CustomResourceValidation{
+				OpenAPIV3Schema: fullSchema,
+			},
+			Subresources: v1Subresources(v1beta1Schema),
+			Storage:      v == storage,
+		})
+	}
+
+	preserveUnknownFields := false
+	p.CustomResourceDefinitionsV1[groupKind] = apiextv1.CustomResourceDefinition{
+		Spec: apiextv1.CustomResourceDefinitionSpec{
+			Group: groupKind.Group,
+			Names: apiextv1.CustomResourceDefinitionNames{
+				Kind:     groupKind.Kind,
+				ListKind: groupKind.Kind + "List",
+				Plural:   defaultPlural(groupKind.Kind),
+				Singular: defaultSingular(groupKind.Kind),
+			},
+			PreserveUnknownFields: &preserveUnknownFields,
+			Versions:              versions,
+		},
+	}
+}
+
+// schemaToV1 converts a v1beta1 JSONSchemaProps tree (produced by the existing
+// schemaContext/infoToSchema walk) into its apiextensions/v1 equivalent.  The two
+// types are structurally identical on the wire, so round-tripping through JSON
+// avoids a second, parallel type-walking implementation just to change package.
+func schemaToV1(in *apiext.JSONSchemaProps) (*apiextv1.JSONSchemaProps, error) {
+	raw, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+	out := &apiextv1.JSONSchemaProps{}
+	if err := json.Unmarshal(raw, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// v1Subresources mirrors the status subresource detection used for the v1beta1
+// CRD so the v1 CRD keeps the same shape.
+func v1Subresources(schema apiext.JSONSchemaProps) *apiextv1.CustomResourceSubresources {
+	if _, hasStatus := schema.Properties["status"]; !hasStatus {
+		return nil
+	}
+	return &apiextv1.CustomResourceSubresources{
+		Status: &apiextv1.CustomResourceSubresourceStatus{},
+	}
+}
+
+// trimDescription truncates an overly-long description so generated CRD
+// manifests don't balloon in size; it's applied recursively to properties,
+// items (single-schema form) and additionalProperties.  Other nested schemas
+// (allOf, anyOf, oneOf, not, tuple-form items) are not trimmed.  Shared with the v1beta1 code path in spec.go.
+func trimDescription(schema *apiext.JSONSchemaProps, maxLen int) {
+	if schema == nil {
+		return
+	}
+	if maxLen >= 0 && len(schema.Description) > maxLen {
+		schema.Description = schema.Description[:maxLen] + " ..."
+	}
+	for name, prop := range schema.Properties {
+		trimDescription(&prop, maxLen)
+		schema.Properties[name] = prop
+	}
+	if schema.Items != nil {
+		trimDescription(schema.Items.Schema, maxLen)
+	}
+	if schema.AdditionalProperties != nil {
+		trimDescription(schema.AdditionalProperties.Schema, maxLen)
+	}
+}
+
+// defaultPlural makes a best-effort guess at the plural form of a Kind, for
+// use when no explicit plural is given.  Shared with the v1beta1 code path
+// in spec.go.
+func defaultPlural(kind string) string {
+	lower := strings.ToLower(kind)
+	switch {
+	case strings.HasSuffix(lower, "s"):
+		return lower + "es"
+	case strings.HasSuffix(lower, "y"):
+		return lower[:len(lower)-1] + "ies"
+	default:
+		return lower + "s"
+	}
+}
+
+// defaultSingular makes a best-effort guess at the singular form of a Kind,
+// for use when no explicit singular is given.  Shared with the v1beta1 code
+// path in spec.go.
+func defaultSingular(kind string) string {
+	return strings.ToLower(kind)
+}
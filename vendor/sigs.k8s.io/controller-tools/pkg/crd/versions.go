@@ -0,0 +1,120 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crd
+
+import (
+	"fmt"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/version"
+
+	"sigs.k8s.io/controller-tools/pkg/loader"
+)
+
+// resolvedVersion is the apiVersion-agnostic result of matching one package
+// against a CRD's group-kind: which package supplies this version, its
+// TypeIdent, and the version name itself.  Which one is the storage version
+// is returned separately by resolveVersionsFor.
+// NeedCRDFor and NeedCRDForV1 each turn a resolvedVersion into their own
+// apiext/apiextv1 CustomResourceDefinitionVersion; the package walk, version
+// ordering, and storage-version validation live here once instead of being
+// duplicated in both.
+type resolvedVersion struct {
+	pkg       *loader.Package
+	typeIdent TypeIdent
+	version   string
+}
+
+// resolveVersionsFor finds every package that shares groupKind's group and
+// declares a type for groupKind.Kind, and returns one resolvedVersion per
+// match, ordered the way Kubernetes orders API versions for discovery
+// (stable before beta before alpha, numeric-aware within each tier, e.g.
+// v1 > v1beta1 > v2alpha1 > v1alpha1).  It also validates that exactly one
+// matching package's root type carries a "+kubebuilder:storageversion"
+// marker, defaulting a lone version to storage implicitly; storageVersion is
+// the resolvedVersion that should have Storage set.
+//
+// kindPkg is nil only if no package in the group declares the Kind at all,
+// in which case an "unknown kind" error has already been recorded against
+// the first package in the group (no error is recorded if the group has no
+// packages at all). versions is nil whenever an error has
+// been recorded (unknown kind, or a storage-version marker count other than
+// one), so callers can treat "versions == nil" as "stop, nothing to build".
+func (p *Parser) resolveVersionsFor(groupKind schema.GroupKind) (kindPkg *loader.Package, versions []resolvedVersion, storageVersion resolvedVersion) {
+	p.init()
+
+	packages := p.packagesForGroup(groupKind.Group)
+
+	for _, pkg := range packages {
+		typeIdent := TypeIdent{Package: pkg, Name: groupKind.Kind}
+		if _, knownKind := p.Types[typeIdent]; !knownKind {
+			continue
+		}
+		if kindPkg == nil {
+			kindPkg = pkg
+		}
+		versions = append(versions, resolvedVersion{
+			pkg:       pkg,
+			typeIdent: typeIdent,
+			version:   p.GroupVersions[pkg].Version,
+		})
+	}
+
+	if kindPkg == nil {
+		if len(packages) > 0 {
+			packages[0].AddError(fmt.Errorf("unknown kind %s in group %s", groupKind.Kind, groupKind.Group))
+		}
+		return nil, nil, resolvedVersion{}
+	}
+
+	storageVersions := 0
+	for _, v := range versions {
+		if hasStorageVersionMarker(p.Types[v.typeIdent]) {
+			storageVersions++
+			storageVersion = v
+		}
+	}
+	if storageVersions == 0 && len(versions) == 1 {
+		storageVersion = versions[0]
+		storageVersions = 1
+	}
+	if storageVersions != 1 {
+		kindPkg.AddError(fmt.Errorf("expected exactly one +kubebuilder:storageversion marker among the versions of %s, got %d", groupKind, storageVersions))
+		return kindPkg, nil, resolvedVersion{}
+	}
+
+	return kindPkg, versions, storageVersion
+}
+
+// packagesForGroup returns every package known to the parser that declares
+// the given group, ordered the way Kubernetes orders API versions for
+// discovery (stable before beta before alpha, numeric-aware within each
+// tier) rather than plain lexicographic order -- otherwise "v1alpha1" would
+// sort before the more-preferred "v1beta1", and "v1" before "v2".
+func (p *Parser) packagesForGroup(group string) []*loader.Package {
+	var packages []*loader.Package
+	for pkg, gv := range p.GroupVersions {
+		if gv.Group == group {
+			packages = append(packages, pkg)
+		}
+	}
+	sort.Slice(packages, func(i, j int) bool {
+		return version.CompareKubeAwareVersionStrings(p.GroupVersions[packages[i]].Version, p.GroupVersions[packages[j]].Version) > 0
+	})
+	return packages
+}